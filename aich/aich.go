@@ -0,0 +1,385 @@
+/*
+Package aich implements an AICH (Advanced Intelligent Corruption Handling)
+hasher, as used by the eDonkey/Kad network to recover from a single corrupt
+ed2k chunk without re-downloading the whole file.
+
+AICH is a Merkle tree of SHA1 digests built on top of the same chunk
+boundaries as the ed2k hash (see the ed2k package): each BlockSize chunk is
+split into fixed-size sub-blocks, SHA1 is computed over each sub-block, and
+the sub-block hashes are combined pairwise, bottom-up, into a per-chunk
+root. The per-chunk roots are then combined the same way into the file's
+AICH root hash. When a level has an odd number of nodes, the last node is
+carried up to the next level unchanged rather than being paired with
+itself.
+*/
+package aich
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/Jessidhia/go-ed2k"
+	"github.com/Jessidhia/go-ed2k/internal/gate"
+)
+
+// The size of the AICH hash in bytes.
+const Size = sha1.Size
+
+// The size of each ed2k chunk that gets its own AICH root, in bytes.
+const BlockSize = ed2k.BlockSize
+
+// The size of each AICH sub-block (leaf of the per-chunk tree), in bytes.
+// BlockSize is not a multiple of this, so the last sub-block of a chunk is
+// shorter than the rest.
+const SubBlockSize = 184320
+
+// The number of sub-blocks that make up a full chunk.
+const subBlocksPerChunk = (BlockSize + SubBlockSize - 1) / SubBlockSize
+
+// A hash.Hash that also needs to be Close()d when done.
+type HashCloser interface {
+	hash.Hash
+	io.Closer
+}
+
+// snapshot is what hashLoop hands back on request: the completed per-chunk
+// roots so far, plus the sub-block hashes accumulated for the chunk that is
+// still being assembled (if any).
+type snapshot struct {
+	chunkRoots       [][Size]byte
+	pendingSubHashes [][Size]byte
+}
+
+type digest struct {
+	currentSubBlock []byte
+	chunkRemaining  int // bytes left before the current BlockSize chunk ends
+
+	// gate bounds how many currentSubBlock buffers may be mid-hash at
+	// once; shared with an ed2k digest when this digest came from
+	// NewCombined, same convention as ed2k.digest (see internal/gate).
+	gate *gate.Gate
+
+	reqTree  chan bool
+	treeOut  chan snapshot
+	addHash  chan chan [Size]byte
+	quitLoop chan bool
+}
+
+// hashLoop runs the background hasher. Backpressure (bounding how many
+// sub-blocks Write lets get ahead of the hashers) is handled by d.gate, not
+// by this loop — see ed2k.digest.hashLoop, which uses the same gate type.
+func (d *digest) hashLoop() {
+	var (
+		notify        bool
+		subHashes     = make([][Size]byte, 0, subBlocksPerChunk)
+		chunkRoots    = make([][Size]byte, 0)
+		runningHashes = make([]chan [Size]byte, 0)
+	)
+	for {
+		var nextHash <-chan [Size]byte
+
+		if len(runningHashes) > 0 {
+			nextHash = runningHashes[0]
+		} else if notify {
+			notify = false
+			d.treeOut <- snapshot{
+				chunkRoots:       append([][Size]byte(nil), chunkRoots...),
+				pendingSubHashes: append([][Size]byte(nil), subHashes...),
+			}
+		}
+
+		select {
+		case c := <-d.addHash:
+			runningHashes = append(runningHashes, c)
+		case h := <-nextHash:
+			subHashes = append(subHashes, h)
+			runningHashes = runningHashes[1:]
+			d.gate.Release()
+			if len(subHashes) == subBlocksPerChunk {
+				chunkRoots = append(chunkRoots, rootOf(subHashes))
+				subHashes = subHashes[:0]
+			}
+		case <-d.reqTree:
+			notify = true
+		case <-d.quitLoop:
+			go func() {
+				for _, c := range runningHashes {
+					<-c
+					d.gate.Release()
+				}
+			}()
+			if notify {
+				d.treeOut <- snapshot{}
+			}
+			d.quitLoop <- true
+			return
+		}
+	}
+}
+
+func (d *digest) Reset() {
+	d.chunkRemaining = BlockSize
+	d.currentSubBlock = make([]byte, 0, subBlockCap(d.chunkRemaining))
+	if d.gate == nil {
+		d.gate = gate.New()
+	}
+
+	if d.quitLoop != nil {
+		d.quitLoop <- true
+		<-d.quitLoop
+	} else {
+		d.reqTree = make(chan bool)
+		d.treeOut = make(chan snapshot)
+		d.addHash = make(chan chan [Size]byte)
+		d.quitLoop = make(chan bool)
+	}
+
+	go d.hashLoop()
+}
+
+// Stops the background hasher and releases all memory used by sub-blocks.
+//
+// The hash can be used again if it's Reset().
+func (d *digest) Close() error {
+	if d.quitLoop != nil {
+		d.quitLoop <- true
+		<-d.quitLoop
+		d.quitLoop = nil
+
+		d.currentSubBlock = nil
+	}
+	return nil
+}
+
+// New returns a new hash.Hash computing the AICH root hash.
+//
+// See hash.Hash for the interface.
+func New() HashCloser {
+	d := &digest{}
+	d.Reset()
+	return d
+}
+
+// NewWithGate is New, but the sub-block buffer backpressure (see
+// internal/gate) is shared through g instead of private to the returned
+// digest. It exists for NewCombined, so ed2k and aich hashing in the same
+// pass draw from one shared pool of in-flight chunk buffers rather than
+// each capping its own independently.
+func NewWithGate(g *gate.Gate) HashCloser {
+	d := &digest{gate: g}
+	d.Reset()
+	return d
+}
+
+func (d *digest) Size() int      { return Size }
+func (d *digest) BlockSize() int { return SubBlockSize }
+
+func (d *digest) Write(p []byte) (i int, err error) {
+	for i = 0; i < len(p); {
+		count := copy(d.currentSubBlock[len(d.currentSubBlock):cap(d.currentSubBlock)], p[i:])
+		d.currentSubBlock = d.currentSubBlock[:len(d.currentSubBlock)+count]
+		i += count
+		if len(d.currentSubBlock) == cap(d.currentSubBlock) && len(p[i:]) > 0 {
+			// blocks until the gate has room, bounding how many
+			// currentSubBlock buffers can be alive waiting to be hashed
+			d.gate.Acquire()
+			d.addHash <- sha1SumAsync(d.currentSubBlock)
+			d.chunkRemaining -= len(d.currentSubBlock)
+			if d.chunkRemaining == 0 {
+				d.chunkRemaining = BlockSize
+			}
+			// the old currentSubBlock now belongs to the sha1 goroutine, make a new one
+			d.currentSubBlock = make([]byte, 0, subBlockCap(d.chunkRemaining))
+		}
+	}
+	return
+}
+
+// subBlockCap returns the capacity of a sub-block buffer so that it never
+// crosses a BlockSize chunk boundary: the last sub-block of a chunk is
+// shorter than SubBlockSize whenever BlockSize isn't a multiple of it.
+func subBlockCap(chunkRemaining int) int {
+	if chunkRemaining < SubBlockSize {
+		return chunkRemaining
+	}
+	return SubBlockSize
+}
+
+// Tree returns the file's per-chunk AICH roots and lets the caller produce
+// a recovery packet for any single chunk via Packet, without re-hashing the
+// whole file.
+func (d *digest) Tree() Tree {
+	currentSubBlock := d.currentSubBlock
+
+	d.reqTree <- true
+	snap := <-d.treeOut
+
+	subHashes := snap.pendingSubHashes
+	if len(currentSubBlock) > 0 {
+		subHashes = append(subHashes, sha1Sum(currentSubBlock))
+	}
+	chunkRoots := snap.chunkRoots
+	if len(subHashes) > 0 {
+		chunkRoots = append(chunkRoots, rootOf(subHashes))
+	}
+	return Tree{ChunkRoots: chunkRoots}
+}
+
+func (d *digest) Sum(p []byte) []byte {
+	tree := d.Tree()
+	root := tree.RootHash()
+	return append(p, root[:]...)
+}
+
+func (d *digest) String() string {
+	return fmt.Sprintf("%x", d.Sum(nil))
+}
+
+// Tree holds the per-chunk AICH roots produced by a digest, in file order.
+type Tree struct {
+	ChunkRoots [][Size]byte
+}
+
+// RootHash returns the file's AICH master hash, combining ChunkRoots up the
+// recovery tree.
+func (t Tree) RootHash() [Size]byte {
+	return rootOf(append([][Size]byte(nil), t.ChunkRoots...))
+}
+
+// Packet returns the sibling hashes needed to verify ChunkRoots[chunk]
+// against RootHash(), ordered from the bottom of the recovery tree to the
+// top. Combined with the chunk's own (re-hashed) root, VerifyPacket checks
+// that single chunk without touching the rest of the file.
+func (t Tree) Packet(chunk int) ([][Size]byte, error) {
+	if chunk < 0 || chunk >= len(t.ChunkRoots) {
+		return nil, fmt.Errorf("aich: chunk %d out of range (have %d chunks)", chunk, len(t.ChunkRoots))
+	}
+
+	level := append([][Size]byte(nil), t.ChunkRoots...)
+	idx := chunk
+	var packet [][Size]byte
+	for len(level) > 1 {
+		carried := len(level)%2 == 1 && idx == len(level)-1
+		if !carried {
+			packet = append(packet, level[idx^1])
+		}
+		level = combineLevel(level)
+		if carried {
+			idx = len(level) - 1
+		} else {
+			idx /= 2
+		}
+	}
+	return packet, nil
+}
+
+// VerifyPacket recomputes the AICH root from a chunk's own root and the
+// sibling hashes returned by Tree.Packet, and reports whether it matches
+// want. chunkCount is the total number of chunks in the file.
+func VerifyPacket(chunkRoot [Size]byte, chunk, chunkCount int, packet [][Size]byte, want [Size]byte) bool {
+	hash := chunkRoot
+	idx, length, pi := chunk, chunkCount, 0
+	for length > 1 {
+		carried := length%2 == 1 && idx == length-1
+		if !carried {
+			if pi >= len(packet) {
+				return false
+			}
+			if idx%2 == 0 {
+				hash = combineHashes(hash, packet[pi])
+			} else {
+				hash = combineHashes(packet[pi], hash)
+			}
+			pi++
+			idx /= 2
+		}
+		length = (length + 1) / 2
+		if carried {
+			idx = length - 1
+		}
+	}
+	return pi == len(packet) && hash == want
+}
+
+// combineLevel combines one level of a recovery tree into the next: nodes
+// are paired off and combined with combineHashes; an odd node left over at
+// the end of the level is carried up unchanged.
+func combineLevel(level [][Size]byte) [][Size]byte {
+	next := make([][Size]byte, 0, (len(level)+1)/2)
+	for i := 0; i+1 < len(level); i += 2 {
+		next = append(next, combineHashes(level[i], level[i+1]))
+	}
+	if len(level)%2 == 1 {
+		next = append(next, level[len(level)-1])
+	}
+	return next
+}
+
+// rootOf repeatedly applies combineLevel until a single root hash remains.
+func rootOf(level [][Size]byte) [Size]byte {
+	level = append([][Size]byte(nil), level...)
+	for len(level) > 1 {
+		level = combineLevel(level)
+	}
+	if len(level) == 0 {
+		return [Size]byte{}
+	}
+	return level[0]
+}
+
+func combineHashes(left, right [Size]byte) [Size]byte {
+	h := sha1.New()
+	h.Write(left[:])
+	h.Write(right[:])
+	var out [Size]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+func sha1Sum(data []byte) [Size]byte {
+	var out [Size]byte
+	sum := sha1.Sum(data)
+	copy(out[:], sum[:])
+	return out
+}
+
+func sha1SumAsync(data []byte) chan [Size]byte {
+	c := make(chan [Size]byte)
+	go func() {
+		c <- sha1Sum(data)
+	}()
+	return c
+}
+
+// Combined writes to an ed2k and an AICH digest in a single pass, so
+// hashing a file for both checksums only reads it once.
+type Combined struct {
+	ED2K ed2k.HashCloser
+	AICH HashCloser
+	w    io.Writer
+}
+
+// NewCombined returns a Combined ready to be Write()n (or io.Copy()'d)
+// into. Each Write fans out to both embedded digests via io.MultiWriter, so
+// ED2K and AICH are both produced from one pass over the data, each still
+// backed by its own background hashing goroutines, but sharing a single
+// gate.Gate to bound the total number of chunk/sub-block buffers the two
+// keep in flight together (see internal/gate).
+func NewCombined() *Combined {
+	g := gate.New()
+	e := ed2k.NewWithGate(false, g)
+	a := NewWithGate(g)
+	return &Combined{ED2K: e, AICH: a, w: io.MultiWriter(e, a)}
+}
+
+func (c *Combined) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *Combined) Close() error {
+	err := c.ED2K.Close()
+	if aerr := c.AICH.Close(); err == nil {
+		err = aerr
+	}
+	return err
+}
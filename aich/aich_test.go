@@ -0,0 +1,98 @@
+package aich_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Jessidhia/go-ed2k/aich"
+)
+
+// A "fake" reader that never writes anything to the []byte.
+// Effectively always reads len(p) of NULs.
+type FakeReader struct{}
+
+func (_ *FakeReader) Read(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+var fakeReader = &FakeReader{}
+
+func TestEmpty(t *testing.T) {
+	h := aich.New()
+	defer h.Close()
+	if got := h.(fmt.Stringer).String(); got == "" {
+		t.Errorf("String() of empty hash was empty")
+	}
+}
+
+func TestSmallExample(t *testing.T) {
+	h := aich.New()
+	defer h.Close()
+	io.Copy(h, strings.NewReader("small example"))
+	// a single short sub-block, single chunk: the AICH root is just the
+	// SHA1 of the data itself
+	want := "cbed23046fb6ca56f0652839dde07ff1272100f7"
+	if got := h.(fmt.Stringer).String(); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestTreeAndPacket(t *testing.T) {
+	// wantChunks of 3 and 5 exercise combineLevel's odd-node case, where a
+	// node without a sibling is carried up a level unchanged.
+	for _, wantChunks := range []int64{1, 3, 4, 5} {
+		size := (wantChunks-1)*aich.BlockSize + 1234
+		h := aich.New()
+		io.CopyN(h, fakeReader, size)
+
+		tree := h.(interface{ Tree() aich.Tree }).Tree()
+		if int64(len(tree.ChunkRoots)) != wantChunks {
+			t.Fatalf("size=%d: got %d chunk roots, want %d", size, len(tree.ChunkRoots), wantChunks)
+		}
+		if got, want := h.(interface{ String() string }).String(), hexString(tree.RootHash()); got != want {
+			t.Errorf("size=%d: Sum() = %#v, want %#v (from Tree().RootHash())", size, got, want)
+		}
+
+		for i, root := range tree.ChunkRoots {
+			packet, err := tree.Packet(i)
+			if err != nil {
+				t.Fatalf("size=%d: Packet(%d): %v", size, i, err)
+			}
+			if !aich.VerifyPacket(root, i, len(tree.ChunkRoots), packet, tree.RootHash()) {
+				t.Errorf("size=%d: VerifyPacket(%d) did not reconstruct the root", size, i)
+			}
+		}
+
+		if _, err := tree.Packet(-1); err == nil {
+			t.Errorf("size=%d: Packet(-1) should have failed", size)
+		}
+		if _, err := tree.Packet(len(tree.ChunkRoots)); err == nil {
+			t.Errorf("size=%d: Packet(out of range) should have failed", size)
+		}
+		h.Close()
+	}
+}
+
+func hexString(h [aich.Size]byte) string {
+	const hextable = "0123456789abcdef"
+	buf := make([]byte, 2*len(h))
+	for i, b := range h {
+		buf[2*i] = hextable[b>>4]
+		buf[2*i+1] = hextable[b&0xf]
+	}
+	return string(buf)
+}
+
+func TestCombined(t *testing.T) {
+	c := aich.NewCombined()
+	defer c.Close()
+	io.CopyN(c, fakeReader, aich.BlockSize+1)
+
+	edSum := c.ED2K.(interface{ String() string }).String()
+	aichSum := c.AICH.(interface{ String() string }).String()
+	if edSum == "" || aichSum == "" {
+		t.Errorf("expected both ED2K and AICH sums to be populated")
+	}
+}
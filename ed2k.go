@@ -9,12 +9,18 @@ Calling Sum() will wait for the hashing goroutines.
 package ed2k
 
 import (
+	"encoding"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash"
 	"io"
-	"runtime"
+	"sync"
 
 	"golang.org/x/crypto/md4"
+
+	"github.com/Jessidhia/go-ed2k/internal/gate"
 )
 
 // The size of the ed2k checksum in bytes.
@@ -29,9 +35,25 @@ type HashCloser interface {
 	io.Closer
 }
 
+// BlockHasher is implemented by the values returned from New; it exposes
+// the per-chunk MD4 digests behind the final root hash, so a caller
+// holding a trusted root can verify or repair part of a file without
+// rehashing all of it. See Tree and VerifyRange.
+type BlockHasher interface {
+	BlockHashes() [][Size]byte
+	Tree() Tree
+}
+
 type digest struct {
 	currentChunk     []byte
 	endWithNullChunk bool
+	bytesWritten     int64
+
+	// gate bounds how many currentChunk-sized buffers may be mid-hash at
+	// once; shared with an aich.digest when this digest came from
+	// aich.NewCombined, so the two don't each enforce their own cap on top
+	// of the other (see internal/gate).
+	gate *gate.Gate
 
 	reqCurrentHashes chan bool
 	currentHashes    chan []byte
@@ -39,23 +61,20 @@ type digest struct {
 	quitLoop         chan bool
 }
 
-func (d *digest) hashLoop() {
+// hashLoop runs the background hasher, seeded with the digests of any
+// chunks already hashed (empty for a fresh digest, non-empty when resuming
+// via UnmarshalBinary). Backpressure (bounding how many chunks Write lets
+// get ahead of the hashers) is handled by d.gate, not by this loop.
+func (d *digest) hashLoop(initialHashList []byte) {
 	var (
 		notify        bool
-		hashList      = make([]byte, 0)
+		hashList      = append([]byte(nil), initialHashList...)
 		runningHashes = make([]chan []byte, 0)
-		maxProcs      = runtime.GOMAXPROCS(0)
 	)
 	for {
 		var nextHash <-chan []byte
 
-		addHash := d.addHash
-		if l := len(runningHashes); l > 0 {
-			// make Write() block if we already have 2*GOMAXPROCS hashes flying
-			// avoids having to keep too many live block slices around, specially since they're almost 10MB each
-			if l >= 2*maxProcs {
-				addHash = nil
-			}
+		if len(runningHashes) > 0 {
 			nextHash = runningHashes[0]
 		} else if notify {
 			notify = false
@@ -65,11 +84,12 @@ func (d *digest) hashLoop() {
 		}
 
 		select {
-		case c := <-addHash:
+		case c := <-d.addHash:
 			runningHashes = append(runningHashes, c)
 		case hash := <-nextHash:
 			hashList = append(hashList, hash...)
 			runningHashes = runningHashes[1:]
+			d.gate.Release()
 		case <-d.reqCurrentHashes:
 			notify = true
 		case <-d.quitLoop:
@@ -77,6 +97,7 @@ func (d *digest) hashLoop() {
 			go func() {
 				for _, c := range runningHashes {
 					<-c
+					d.gate.Release()
 				}
 			}()
 			if notify {
@@ -90,6 +111,10 @@ func (d *digest) hashLoop() {
 
 func (d *digest) Reset() {
 	d.currentChunk = make([]byte, 0, BlockSize)
+	d.bytesWritten = 0
+	if d.gate == nil {
+		d.gate = gate.New()
+	}
 
 	if d.quitLoop != nil {
 		d.quitLoop <- true
@@ -101,7 +126,7 @@ func (d *digest) Reset() {
 		d.addHash = make(chan chan []byte)
 	}
 
-	go d.hashLoop()
+	go d.hashLoop(nil)
 }
 
 // Stops the background hasher and releases all memory
@@ -119,6 +144,124 @@ func (d *digest) Close() error {
 	return nil
 }
 
+// binaryVersion is the format version written by MarshalBinary, so future
+// versions of this package can tell an old encoding apart from a
+// corrupted one.
+const binaryVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler, letting a long-running
+// hash be suspended and later resumed with UnmarshalBinary — e.g. across
+// process restarts while hashing a multi-gigabyte file.
+func (d *digest) MarshalBinary() ([]byte, error) {
+	hashList := d.rawHashList()
+
+	buf := make([]byte, 0, 1+1+8+4+len(hashList)+4+len(d.currentChunk))
+	buf = append(buf, binaryVersion)
+	if d.endWithNullChunk {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint64(lenBuf[:], uint64(d.bytesWritten))
+	buf = append(buf, lenBuf[:]...)
+
+	buf = appendUint32Prefixed(buf, hashList)
+	buf = appendUint32Prefixed(buf, d.currentChunk)
+
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It stops any
+// background hashing already in progress, restores the state written by
+// MarshalBinary, and restarts hashLoop so that subsequent Writes continue
+// from exactly the chunk boundary they left off at.
+func (d *digest) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errors.New("ed2k: UnmarshalBinary: empty data")
+	}
+	if data[0] != binaryVersion {
+		return fmt.Errorf("ed2k: UnmarshalBinary: unsupported version %d", data[0])
+	}
+	data = data[1:]
+
+	if len(data) < 1 {
+		return errors.New("ed2k: UnmarshalBinary: truncated data")
+	}
+	endWithNullChunk := data[0] != 0
+	data = data[1:]
+
+	if len(data) < 8 {
+		return errors.New("ed2k: UnmarshalBinary: truncated data")
+	}
+	bytesWritten := int64(binary.BigEndian.Uint64(data[:8]))
+	data = data[8:]
+
+	hashList, data, err := takeUint32Prefixed(data)
+	if err != nil {
+		return fmt.Errorf("ed2k: UnmarshalBinary: hash list: %w", err)
+	}
+
+	currentChunkData, _, err := takeUint32Prefixed(data)
+	if err != nil {
+		return fmt.Errorf("ed2k: UnmarshalBinary: current chunk: %w", err)
+	}
+	currentChunk := make([]byte, len(currentChunkData), BlockSize)
+	copy(currentChunk, currentChunkData)
+
+	if d.quitLoop != nil {
+		d.quitLoop <- true
+		<-d.quitLoop
+	} else {
+		d.reqCurrentHashes = make(chan bool)
+		d.quitLoop = make(chan bool)
+		d.currentHashes = make(chan []byte)
+		d.addHash = make(chan chan []byte)
+	}
+
+	d.endWithNullChunk = endWithNullChunk
+	d.bytesWritten = bytesWritten
+	d.currentChunk = currentChunk
+
+	go d.hashLoop(hashList)
+
+	return nil
+}
+
+// rawHashList snapshots the MD4 digests of the chunks fully hashed so far,
+// without folding in the chunk currently being written. This is the
+// resumable state MarshalBinary saves; compare completedHashList, which
+// folds the in-progress chunk in to match what Sum returns.
+func (d *digest) rawHashList() []byte {
+	d.reqCurrentHashes <- true
+	return <-d.currentHashes
+}
+
+// appendUint32Prefixed appends data to buf, preceded by a 4-byte big-endian
+// length so it can be split back out again by takeUint32Prefixed.
+func appendUint32Prefixed(buf []byte, data []byte) []byte {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	buf = append(buf, lenBuf[:]...)
+	return append(buf, data...)
+}
+
+// takeUint32Prefixed is the inverse of appendUint32Prefixed: it reads a
+// 4-byte big-endian length off the front of data, then returns that many
+// following bytes and whatever is left over.
+func takeUint32Prefixed(data []byte) (taken, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("truncated length prefix")
+	}
+	n := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint32(len(data)) < n {
+		return nil, nil, errors.New("truncated data")
+	}
+	return append([]byte(nil), data[:n]...), data[n:], nil
+}
+
 // New returns a new hash.Hash computing the ed2k checksum.
 //
 // The bool argument chooses between the new (false) or old (true) blockchain finishing algorithm.
@@ -134,6 +277,22 @@ func New(endWithNullChunk bool) HashCloser {
 	return d
 }
 
+// NewWithGate is New, but the chunk-buffer backpressure (see internal/gate)
+// is shared through g instead of private to the returned digest. It exists
+// for aich.NewCombined, so ed2k and aich hashing in the same pass draw from
+// one shared pool of in-flight chunk buffers rather than each capping its
+// own independently.
+func NewWithGate(endWithNullChunk bool, g *gate.Gate) HashCloser {
+	d := &digest{endWithNullChunk: endWithNullChunk, gate: g}
+	d.Reset()
+	return d
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*digest)(nil)
+	_ encoding.BinaryUnmarshaler = (*digest)(nil)
+)
+
 func (d *digest) Size() int      { return Size }
 func (d *digest) BlockSize() int { return BlockSize }
 
@@ -142,7 +301,11 @@ func (d *digest) Write(p []byte) (i int, err error) {
 		count := copy(d.currentChunk[len(d.currentChunk):cap(d.currentChunk)], p[i:])
 		d.currentChunk = d.currentChunk[:len(d.currentChunk)+count]
 		i += count
+		d.bytesWritten += int64(count)
 		if len(d.currentChunk) == cap(d.currentChunk) && len(p[i:]) > 0 {
+			// blocks until the gate has room, bounding how many currentChunk
+			// buffers (almost 10MB each) can be alive waiting to be hashed
+			d.gate.Acquire()
 			d.addHash <- md4SumAsync(d.currentChunk)
 			// the old currentChunk now belongs to the md4 goroutine, make a new one
 			d.currentChunk = make([]byte, 0, BlockSize)
@@ -151,24 +314,36 @@ func (d *digest) Write(p []byte) (i int, err error) {
 	return
 }
 
-func (d *digest) Sum(p []byte) []byte {
+// completedHashList returns the same bytes Sum folds into the final ed2k
+// hash: the MD4 digest of every full chunk seen so far, plus the digest of
+// the chunk currently being written. single reports whether that is
+// actually the single already-final digest Sum returns directly (when the
+// data hashed so far fits in one never-dispatched chunk, ed2k hashes it
+// once instead of chunking).
+func (d *digest) completedHashList() (hashList []byte, single bool) {
 	currentChunk := d.currentChunk
-
-	d.reqCurrentHashes <- true
-	hashList := <-d.currentHashes
+	hashList = d.rawHashList()
 
 	if d.endWithNullChunk && len(currentChunk) == cap(currentChunk) {
 		hashList = md4Sum(currentChunk, hashList)
 		currentChunk = currentChunk[:0] // Leave a null chunk for appending
 	} else if len(hashList) == 0 {
 		// We just hash the data itself, instead of "chunking"
-		return md4Sum(currentChunk, nil)
+		return md4Sum(currentChunk, nil), true
 	}
 	// We always append a chunk if d.endWithNullChunk, regardless of length
 	if d.endWithNullChunk || len(currentChunk) > 0 {
 		hashList = md4Sum(currentChunk, hashList)
 	}
 
+	return hashList, false
+}
+
+func (d *digest) Sum(p []byte) []byte {
+	hashList, single := d.completedHashList()
+	if single {
+		return hashList
+	}
 	return md4Sum(hashList, p)
 }
 
@@ -176,12 +351,178 @@ func (d *digest) String() string {
 	return hex.EncodeToString(d.Sum(nil))
 }
 
+// BlockHashes returns the ordered list of per-chunk MD4 digests hashed so
+// far, including the chunk currently being written. It is equivalent to
+// Tree().Blocks.
+func (d *digest) BlockHashes() [][Size]byte {
+	return d.Tree().Blocks
+}
+
+// Tree returns the per-chunk MD4 digests behind the ed2k hash, along with
+// the finishing mode that combines them, so VerifyRange can later check an
+// arbitrary byte range against a trusted root without rehashing the whole
+// file.
+func (d *digest) Tree() Tree {
+	hashList, _ := d.completedHashList()
+	return Tree{Blocks: splitBlocks(hashList), EndWithNullChunk: d.endWithNullChunk}
+}
+
+// Tree records the ordered per-chunk MD4 digests behind an ed2k hash.
+type Tree struct {
+	// Blocks holds one MD4 digest per BlockSize chunk, in file order. The
+	// last entry may cover a short final chunk.
+	Blocks [][Size]byte
+	// EndWithNullChunk is the finishing mode (see New) that produced this
+	// Tree.
+	EndWithNullChunk bool
+}
+
+// RootHash recomputes the ed2k root hash from Blocks, the same way Sum
+// would from a fully hashed file.
+func (t Tree) RootHash() [Size]byte {
+	var out [Size]byte
+	if len(t.Blocks) == 1 {
+		return t.Blocks[0]
+	}
+	buf := make([]byte, 0, len(t.Blocks)*Size)
+	for _, b := range t.Blocks {
+		buf = append(buf, b[:]...)
+	}
+	copy(out[:], md4Sum(buf, nil))
+	return out
+}
+
+// VerifyRange checks the bytes [offset, offset+length) of r against tree
+// by rehashing only the BlockSize chunks that range touches, instead of
+// the whole file. tree must have been produced against the same trusted
+// root that r is meant to match (e.g. by an earlier, successful Tree()).
+//
+// It returns an error identifying the first chunk whose content no longer
+// matches tree.Blocks, so a caller can re-download or repair just that
+// chunk instead of the entire file.
+func VerifyRange(r io.ReaderAt, offset, length int64, tree *Tree) error {
+	if offset < 0 || length < 0 {
+		return fmt.Errorf("ed2k: invalid range [%d, %d)", offset, offset+length)
+	}
+	if length == 0 {
+		return nil
+	}
+
+	firstChunk := offset / BlockSize
+	lastChunk := (offset + length - 1) / BlockSize
+
+	buf := make([]byte, BlockSize)
+	for chunk := firstChunk; chunk <= lastChunk; chunk++ {
+		if chunk < 0 || int(chunk) >= len(tree.Blocks) {
+			return fmt.Errorf("ed2k: chunk %d out of range (tree has %d blocks)", chunk, len(tree.Blocks))
+		}
+
+		n, err := r.ReadAt(buf, chunk*BlockSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("ed2k: reading chunk %d: %w", chunk, err)
+		}
+
+		var got [Size]byte
+		copy(got[:], md4Sum(buf[:n], nil))
+		if got != tree.Blocks[chunk] {
+			return fmt.Errorf("ed2k: chunk %d does not match the trusted hash", chunk)
+		}
+	}
+	return nil
+}
+
+// HashReaderAt computes the ed2k hash of the first size bytes of r using
+// concurrency worker goroutines that ReadAt a BlockSize chunk at a time and
+// hash it directly, instead of going through the serialized Write path New
+// uses. Each worker reuses its own fixed-size buffer across chunks, which
+// avoids the large BlockSize copies Write does to support streaming input.
+//
+// It returns the root hash and the per-chunk MD4 digests that went into
+// it, in file order (see Tree). concurrency values below 1 are treated as 1.
+func HashReaderAt(r io.ReaderAt, size int64, endWithNullChunk bool, concurrency int) ([Size]byte, [][Size]byte, error) {
+	if size < 0 {
+		return [Size]byte{}, nil, fmt.Errorf("ed2k: HashReaderAt: negative size %d", size)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	numChunks := 1
+	if size > 0 {
+		numChunks = int((size + BlockSize - 1) / BlockSize)
+	}
+
+	blocks := make([][Size]byte, numChunks)
+	jobs := make(chan int)
+	errs := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, BlockSize)
+			for chunk := range jobs {
+				chunkStart := int64(chunk) * BlockSize
+				chunkLen := int64(BlockSize)
+				if rem := size - chunkStart; rem < chunkLen {
+					chunkLen = rem
+				}
+				n, err := r.ReadAt(buf[:chunkLen], chunkStart)
+				if err != nil && err != io.EOF {
+					select {
+					case errs <- err:
+					default:
+					}
+					continue
+				}
+				blocks[chunk] = md4Block(buf[:n])
+			}
+		}()
+	}
+
+	for chunk := 0; chunk < numChunks; chunk++ {
+		jobs <- chunk
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return [Size]byte{}, nil, err
+	default:
+	}
+
+	tree := Tree{Blocks: blocks, EndWithNullChunk: endWithNullChunk}
+	if endWithNullChunk && size > 0 && size%BlockSize == 0 {
+		tree.Blocks = append(append([][Size]byte(nil), blocks...), md4Block(nil))
+	}
+
+	return tree.RootHash(), tree.Blocks, nil
+}
+
+// splitBlocks splits a flat concatenation of Size-byte digests, as
+// accumulated by hashLoop, into the ordered list of digests it represents.
+func splitBlocks(hashList []byte) [][Size]byte {
+	blocks := make([][Size]byte, len(hashList)/Size)
+	for i := range blocks {
+		copy(blocks[i][:], hashList[i*Size:(i+1)*Size])
+	}
+	return blocks
+}
+
 func md4Sum(data []byte, list []byte) []byte {
 	md4 := md4.New()
 	md4.Write(data)
 	return md4.Sum(list)
 }
 
+func md4Block(data []byte) [Size]byte {
+	var out [Size]byte
+	copy(out[:], md4Sum(data, nil))
+	return out
+}
+
 func md4SumAsync(data []byte) chan []byte {
 	c := make(chan []byte)
 	go func() {
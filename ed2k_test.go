@@ -1,9 +1,12 @@
 package ed2k_test
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -18,6 +21,10 @@ func (_ *FakeReader) Read(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+func (_ *FakeReader) ReadAt(p []byte, off int64) (n int, err error) {
+	return len(p), nil
+}
+
 type testVector struct {
 	Mode bool
 	Data io.Reader
@@ -93,6 +100,123 @@ func bench(B *testing.B, mode bool, size int64) {
 	}
 }
 
+func TestBlockHashesAndVerifyRange(T *testing.T) {
+	data := make([]byte, 2*ed2k.BlockSize+12345)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	h := ed2k.New(false)
+	io.Copy(h, bytes.NewReader(data))
+	tree := h.(ed2k.BlockHasher).Tree()
+
+	if got, want := len(tree.Blocks), 3; got != want {
+		T.Fatalf("got %d blocks, want %d", got, want)
+	}
+	if got, want := hex.EncodeToString(tree.Blocks[len(tree.Blocks)-1][:]), hex.EncodeToString(h.(ed2k.BlockHasher).BlockHashes()[len(tree.Blocks)-1][:]); got != want {
+		T.Errorf("BlockHashes() disagrees with Tree().Blocks: %#v != %#v", got, want)
+	}
+	if root := tree.RootHash(); hex.EncodeToString(root[:]) != h.(fmt.Stringer).String() {
+		T.Errorf("Tree().RootHash() = %#v, want %#v (from Sum)", hex.EncodeToString(root[:]), h.(fmt.Stringer).String())
+	}
+
+	if err := ed2k.VerifyRange(bytes.NewReader(data), ed2k.BlockSize-10, 20, &tree); err != nil {
+		T.Errorf("VerifyRange over unmodified data: %v", err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[ed2k.BlockSize+5]++
+	if err := ed2k.VerifyRange(bytes.NewReader(corrupted), ed2k.BlockSize, 10, &tree); err == nil {
+		T.Errorf("VerifyRange should have failed over the corrupted chunk")
+	}
+	if err := ed2k.VerifyRange(bytes.NewReader(corrupted), 0, 10, &tree); err != nil {
+		T.Errorf("VerifyRange over an untouched chunk: %v", err)
+	}
+}
+
+func TestMarshalBinaryRoundTrip(T *testing.T) {
+	data := make([]byte, 3*ed2k.BlockSize+1234)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	half := len(data) / 2
+
+	want := ed2k.New(false)
+	io.Copy(want, bytes.NewReader(data))
+	wantSum := want.(fmt.Stringer).String()
+
+	h := ed2k.New(false)
+	h.Write(data[:half])
+
+	marshaled, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		T.Fatalf("MarshalBinary: %v", err)
+	}
+
+	resumed := ed2k.New(true) // mode is part of the saved state; should be overwritten
+	if err := resumed.(encoding.BinaryUnmarshaler).UnmarshalBinary(marshaled); err != nil {
+		T.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	resumed.Write(data[half:])
+	if got := resumed.(fmt.Stringer).String(); got != wantSum {
+		T.Errorf("got %#v, want %#v", got, wantSum)
+	}
+}
+
+func TestHashReaderAtNegativeSize(T *testing.T) {
+	if _, _, err := ed2k.HashReaderAt(fakeReader, -1, false, 4); err == nil {
+		T.Errorf("HashReaderAt(size=-1) should have failed")
+	}
+}
+
+func TestHashReaderAt(T *testing.T) {
+	for _, mode := range []bool{false, true} {
+		for _, size := range []int64{0, 13, chunkSize, chunkSize + 1, 2 * chunkSize, 2*chunkSize + 1234} {
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i)
+			}
+
+			want := ed2k.New(mode)
+			io.Copy(want, bytes.NewReader(data))
+			wantSum := want.(fmt.Stringer).String()
+
+			root, blocks, err := ed2k.HashReaderAt(bytes.NewReader(data), size, mode, 4)
+			if err != nil {
+				T.Fatalf("HashReaderAt(size=%d, mode=%v): %v", size, mode, err)
+			}
+			if got := hex.EncodeToString(root[:]); got != wantSum {
+				T.Errorf("HashReaderAt(size=%d, mode=%v) = %#v, want %#v", size, mode, got, wantSum)
+			}
+			if wantBlocks := len(blocks); wantBlocks < 1 {
+				T.Errorf("HashReaderAt(size=%d, mode=%v) returned no blocks", size, mode)
+			}
+			tree := ed2k.Tree{Blocks: blocks, EndWithNullChunk: mode}
+			rootFromBlocks := tree.RootHash()
+			if got := hex.EncodeToString(rootFromBlocks[:]); got != wantSum {
+				T.Errorf("Tree{Blocks: blocks}.RootHash() for HashReaderAt(size=%d, mode=%v) = %#v, want %#v", size, mode, got, wantSum)
+			}
+		}
+	}
+}
+
+func benchReaderAt(B *testing.B, mode bool, size int64) {
+	B.SetBytes(size)
+	B.ResetTimer()
+	for i := 0; i < B.N; i++ {
+		ed2k.HashReaderAt(fakeReader, size, mode, runtime.GOMAXPROCS(0))
+	}
+}
+
+func Benchmark_ReaderAt_1GB(B *testing.B) {
+	benchReaderAt(B, false, 1*1024*1024*1024)
+}
+
+func Benchmark_ReaderAt_10GB(B *testing.B) {
+	benchReaderAt(B, false, 10*1024*1024*1024)
+}
+
 func Benchmark_nullChunk(B *testing.B) {
 	bench(B, true, chunkSize)
 }
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"github.com/Kovensky/go-ed2k"
+	"github.com/Jessidhia/go-ed2k"
 	"io"
 	"io/ioutil"
 	"os"
 	"regexp"
-	"strconv"
+	"runtime"
 	"strings"
 )
 
@@ -19,22 +20,36 @@ var checkMode = flag.Bool("c", false,
 	`If true, takes a previous output of this program and verifies the hashes.`)
 var uriMode = flag.Bool("uri", false,
 	`If true, outputs ed2k URIs instead of a verifiable digest.`)
+var verifyURIMode = flag.Bool("verify-uri", false,
+	`If true, takes an ed2k:// URI and a local path and verifies the file against it,
+                     cross-checking individual chunks if the URI carries a p= hashset.`)
 
 func hashFile(chunkMode bool, path string) (hash string, err error) {
-	var fh *os.File
 	if path == "-" {
-		fh = os.Stdin
-	} else {
-		fh, err = os.Open(path)
-		if err != nil {
-			return
+		e := ed2k.New(chunkMode)
+		io.Copy(e, os.Stdin)
+		return e.(fmt.Stringer).String(), nil
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	// A seekable, regular file can be hashed with concurrent pread()s
+	// instead of a single serialized io.Copy.
+	if fi, statErr := fh.Stat(); statErr == nil && fi.Mode().IsRegular() {
+		root, _, hashErr := ed2k.HashReaderAt(fh, fi.Size(), chunkMode, runtime.GOMAXPROCS(0))
+		if hashErr != nil {
+			return "", fmt.Errorf("%s: %w", path, hashErr)
 		}
-		defer fh.Close()
+		return hex.EncodeToString(root[:]), nil
 	}
 
-	ed2k := ed2k.New(chunkMode)
-	io.Copy(ed2k, fh)
-	return ed2k.(fmt.Stringer).String(), err
+	e := ed2k.New(chunkMode)
+	io.Copy(e, fh)
+	return e.(fmt.Stringer).String(), nil
 }
 
 func makeLine(hash string, chunkMode bool, path string) string {
@@ -51,7 +66,67 @@ func makeURI(hash string, path string) string {
 		fmt.Fprintln(os.Stderr, err)
 		return ""
 	}
-	return strings.Join([]string{"ed2k://", "file", path, strconv.FormatInt(fi.Size(), 10), hash, "/"}, "|")
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+	u := &ed2k.URI{Name: path, Size: fi.Size()}
+	copy(u.Hash[:], hashBytes)
+	return u.String()
+}
+
+// verifyURI verifies that the local file at path matches the ed2k:// link
+// uriStr: its size and full hash must match, and if the link carries a p=
+// chunk hashset, every chunk is cross-checked individually so a mismatch
+// can be reported by chunk number.
+func verifyURI(uriStr, path string) error {
+	u, err := ed2k.ParseURI(uriStr)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != u.Size {
+		return fmt.Errorf("%s: size %d does not match URI size %d", path, fi.Size(), u.Size)
+	}
+
+	root, blocks, err := ed2k.HashReaderAt(fh, fi.Size(), *useNullChunk, runtime.GOMAXPROCS(0))
+	if err != nil {
+		return err
+	}
+	if root != u.Hash {
+		return fmt.Errorf("%s: hash %s does not match URI hash %s",
+			path, hex.EncodeToString(root[:]), hex.EncodeToString(u.Hash[:]))
+	}
+
+	if u.Hashset != nil {
+		if len(blocks) != len(u.Hashset) {
+			return fmt.Errorf("%s: has %d chunks, URI hashset has %d", path, len(blocks), len(u.Hashset))
+		}
+		for i, want := range u.Hashset {
+			if blocks[i] != want {
+				return fmt.Errorf("%s: chunk %d hash %s does not match URI hash %s",
+					path, i, hex.EncodeToString(blocks[i][:]), hex.EncodeToString(want[:]))
+			}
+		}
+	}
+
+	if root != u.Hash {
+		return fmt.Errorf("%s: hash %s does not match URI hash %s",
+			path, hex.EncodeToString(root[:]), hex.EncodeToString(u.Hash[:]))
+	}
+
+	return nil
 }
 
 func makeDigest(paths ...string) (digest string) {
@@ -111,7 +186,17 @@ func main() {
 	if len(args) == 0 {
 		args = []string{"-"}
 	}
-	if *checkMode {
+	if *verifyURIMode {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, os.Args[0]+": -verify-uri takes exactly a URI and a local path")
+			os.Exit(2)
+		}
+		if err := verifyURI(args[0], args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(args[1] + ": OK")
+	} else if *checkMode {
 		errCount := 0
 		for _, file := range args {
 			var err error
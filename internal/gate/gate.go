@@ -0,0 +1,30 @@
+// Package gate provides the counting-semaphore backpressure shared by
+// ed2k.digest and aich.digest: a cap on how many large chunk buffers may be
+// mid-hash at once, so a Write blocks instead of letting memory use grow
+// without bound on a fast reader.
+//
+// A single Gate can be handed to more than one digest (see
+// aich.NewCombined), so that hashing two digests over one pass of the same
+// data still only ever has one pool's worth of chunk buffers in flight,
+// rather than each digest enforcing its own cap independently.
+package gate
+
+import "runtime"
+
+// Gate is a counting semaphore of tokens, conventionally sized to
+// 2*GOMAXPROCS — enough to keep hashing goroutines fed without letting
+// Write get arbitrarily far ahead of them.
+type Gate struct {
+	tokens chan struct{}
+}
+
+// New returns a Gate with 2*GOMAXPROCS tokens.
+func New() *Gate {
+	return &Gate{tokens: make(chan struct{}, 2*runtime.GOMAXPROCS(0))}
+}
+
+// Acquire blocks until a token is available.
+func (g *Gate) Acquire() { g.tokens <- struct{}{} }
+
+// Release returns a token to the gate, unblocking one pending Acquire.
+func (g *Gate) Release() { <-g.tokens }
@@ -0,0 +1,191 @@
+package ed2k
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// base32Encoding is the alphabet ed2k URIs use for the optional AICH field:
+// standard RFC 4648 base32, unpadded.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// URI is a parsed ed2k:// link, as used throughout the eDonkey/Kad network
+// to reference a file by name, size and hash:
+//
+//	ed2k://|file|<name>|<size>|<hash>|[h=<AICH>|][p=<hash>:<hash>:...|].../
+//
+// The h= and p= fields are optional. String reproduces a URI carrying the
+// same fields with the same values, but it is not guaranteed to be
+// byte-identical to the string ParseURI read it from: a URI built by hand
+// (rather than round-tripped through ParseURI) always orders h= before p=
+// before any Sources, and one obtained from ParseURI preserves the
+// trailing-field order of the original string, not necessarily whatever
+// order AICH/Hashset/Sources are set in afterwards.
+type URI struct {
+	Name string
+	Size int64
+	Hash [Size]byte
+
+	// AICH is the file's AICH root hash, from an optional h= field. It is
+	// nil if the URI didn't carry one.
+	AICH []byte
+	// Hashset holds the per-chunk MD4 digests from an optional p= field,
+	// in file order. It is nil if the URI didn't carry one.
+	Hashset [][Size]byte
+	// Sources holds any other pipe-delimited fields verbatim, so they
+	// survive a parse/String round-trip even though this package doesn't
+	// interpret them.
+	Sources []string
+
+	// fieldOrder records the order in which h=, p= and Sources fields
+	// appeared in the URI ParseURI read, so String can reproduce it. It
+	// is nil for a URI that wasn't produced by ParseURI, in which case
+	// String falls back to the canonical h=, p=, Sources... order.
+	fieldOrder []byte
+}
+
+// Field-order tags recorded in URI.fieldOrder.
+const (
+	fieldAICH    = 'h'
+	fieldHashset = 'p'
+	fieldSource  = 's'
+)
+
+// ParseURI parses an ed2k:// file link. It rejects URIs that don't have
+// the expected pipe-delimited "ed2k://|file|name|size|hash|.../" shape, a
+// main hash that isn't exactly 32 hex characters, or an h= AICH hash that
+// isn't exactly 32 base32 characters.
+func ParseURI(s string) (*URI, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) < 6 {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: too few |-delimited fields", s)
+	}
+	if parts[0] != "ed2k://" {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: missing ed2k:// prefix", s)
+	}
+	if !strings.EqualFold(parts[1], "file") {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: not a file link", s)
+	}
+	if parts[len(parts)-1] != "/" {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: missing trailing /", s)
+	}
+
+	size, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: bad size: %w", s, err)
+	}
+
+	hash, err := parseHexHash(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("ed2k: malformed URI %q: bad hash: %w", s, err)
+	}
+
+	u := &URI{Name: parts[2], Size: size, Hash: hash}
+
+	for _, field := range parts[5 : len(parts)-1] {
+		switch {
+		case field == "":
+			// tolerate a stray empty field from a doubled pipe
+		case strings.HasPrefix(field, "h="):
+			aich, err := parseBase32Hash(field[len("h="):])
+			if err != nil {
+				return nil, fmt.Errorf("ed2k: malformed URI %q: bad AICH hash: %w", s, err)
+			}
+			u.AICH = aich
+			u.fieldOrder = append(u.fieldOrder, fieldAICH)
+		case strings.HasPrefix(field, "p="):
+			hashset, err := parseHashset(field[len("p="):])
+			if err != nil {
+				return nil, fmt.Errorf("ed2k: malformed URI %q: bad chunk hashset: %w", s, err)
+			}
+			u.Hashset = hashset
+			u.fieldOrder = append(u.fieldOrder, fieldHashset)
+		default:
+			u.Sources = append(u.Sources, field)
+			u.fieldOrder = append(u.fieldOrder, fieldSource)
+		}
+	}
+
+	return u, nil
+}
+
+// String formats u back into an ed2k:// link. See the URI doc comment for
+// the ordering guarantees this provides.
+func (u *URI) String() string {
+	parts := []string{
+		"ed2k://", "file", u.Name, strconv.FormatInt(u.Size, 10), hex.EncodeToString(u.Hash[:]),
+	}
+
+	aich := "h=" + base32Encoding.EncodeToString(u.AICH)
+	hashes := make([]string, len(u.Hashset))
+	for i, h := range u.Hashset {
+		hashes[i] = hex.EncodeToString(h[:])
+	}
+	hashset := "p=" + strings.Join(hashes, ":")
+
+	if u.fieldOrder != nil {
+		sources := u.Sources
+		for _, tag := range u.fieldOrder {
+			switch tag {
+			case fieldAICH:
+				parts = append(parts, aich)
+			case fieldHashset:
+				parts = append(parts, hashset)
+			case fieldSource:
+				parts = append(parts, sources[0])
+				sources = sources[1:]
+			}
+		}
+	} else {
+		if len(u.AICH) > 0 {
+			parts = append(parts, aich)
+		}
+		if len(u.Hashset) > 0 {
+			parts = append(parts, hashset)
+		}
+		parts = append(parts, u.Sources...)
+	}
+
+	parts = append(parts, "/")
+	return strings.Join(parts, "|")
+}
+
+func parseHexHash(s string) ([Size]byte, error) {
+	var out [Size]byte
+	if len(s) != 2*Size {
+		return out, fmt.Errorf("want %d hex characters, got %d", 2*Size, len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return out, err
+	}
+	copy(out[:], b)
+	return out, nil
+}
+
+func parseBase32Hash(s string) ([]byte, error) {
+	if len(s) != 32 {
+		return nil, fmt.Errorf("want 32 base32 characters, got %d", len(s))
+	}
+	b, err := base32Encoding.DecodeString(strings.ToUpper(s))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func parseHashset(s string) ([][Size]byte, error) {
+	fields := strings.Split(s, ":")
+	hashset := make([][Size]byte, len(fields))
+	for i, f := range fields {
+		h, err := parseHexHash(f)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		hashset[i] = h
+	}
+	return hashset, nil
+}
@@ -0,0 +1,77 @@
+package ed2k_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jessidhia/go-ed2k"
+)
+
+func TestParseURIRoundTrip(T *testing.T) {
+	want := "ed2k://|file|some movie.avi|701924520|31d6cfe0d16ae931b73c59d7e0c089c0|/"
+	u, err := ed2k.ParseURI(want)
+	if err != nil {
+		T.Fatalf("ParseURI: %v", err)
+	}
+	if u.Name != "some movie.avi" || u.Size != 701924520 {
+		T.Errorf("got Name=%#v Size=%d, want Name=%#v Size=%d", u.Name, u.Size, "some movie.avi", 701924520)
+	}
+	if got := u.String(); got != want {
+		T.Errorf("String() round-trip: got %#v, want %#v", got, want)
+	}
+}
+
+func TestParseURIWithAICHAndHashset(T *testing.T) {
+	aich := strings.Repeat("A", 32)
+	chunk1 := strings.Repeat("1", 32)
+	chunk2 := strings.Repeat("2", 32)
+	s := "ed2k://|file|x|20000000|31d6cfe0d16ae931b73c59d7e0c089c0|h=" + aich + "|p=" + chunk1 + ":" + chunk2 + "|/"
+
+	u, err := ed2k.ParseURI(s)
+	if err != nil {
+		T.Fatalf("ParseURI: %v", err)
+	}
+	if len(u.AICH) != 20 {
+		T.Errorf("got AICH of %d bytes, want 20", len(u.AICH))
+	}
+	if len(u.Hashset) != 2 {
+		T.Fatalf("got %d hashset entries, want 2", len(u.Hashset))
+	}
+	if got := u.String(); got != s {
+		T.Errorf("String() round-trip: got %#v, want %#v", got, s)
+	}
+}
+
+func TestParseURIPreservesFieldOrder(T *testing.T) {
+	aich := strings.Repeat("A", 32)
+	chunk1 := strings.Repeat("1", 32)
+	chunk2 := strings.Repeat("2", 32)
+	// p= before h=, with a source field interleaved between them: an order
+	// String must not silently reshuffle into its canonical h=, p= layout.
+	s := "ed2k://|file|x|20000000|31d6cfe0d16ae931b73c59d7e0c089c0|p=" + chunk1 + ":" + chunk2 + "|sources,1.2.3.4:4662|h=" + aich + "|/"
+
+	u, err := ed2k.ParseURI(s)
+	if err != nil {
+		T.Fatalf("ParseURI: %v", err)
+	}
+	if got := u.String(); got != s {
+		T.Errorf("String() round-trip: got %#v, want %#v", got, s)
+	}
+}
+
+func TestParseURIMalformed(T *testing.T) {
+	cases := []string{
+		"",
+		"not an ed2k uri at all",
+		"ed2k://|file|x|10|deadbeef|/", // hash too short
+		"ed2k://|file|x|10|31d6cfe0d16ae931b73c59d7e0c089c0",           // missing trailing /
+		"ed2k://|notfile|x|10|31d6cfe0d16ae931b73c59d7e0c089c0|/",      // wrong kind
+		"ed2k://|file|x|notanumber|31d6cfe0d16ae931b73c59d7e0c089c0|/", // bad size
+		"ed2k://|file|x|10|31d6cfe0d16ae931b73c59d7e0c089c0|h=short|/", // bad AICH length
+	}
+	for _, s := range cases {
+		if _, err := ed2k.ParseURI(s); err == nil {
+			T.Errorf("ParseURI(%#v) should have failed", s)
+		}
+	}
+}